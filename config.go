@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds the tunable options, layered from highest to lowest
+// precedence: CLI flags, environment variables, a profile in the TOML
+// config file, the config file's top-level defaults, and finally these
+// built-in defaults. --site, --master, --profile, --entropy, and
+// --min-entropy are not part of Config and are set by CLI flags only.
+type Config struct {
+	Length       int
+	Digits       bool
+	Symbols      bool
+	OnlyDigits   bool
+	MinUpper     int
+	MinLower     int
+	MinDigits    int
+	MinSymbols   int
+	Exclude      string
+	SymbolSet    string
+	NumPasswords int
+	Format       string
+	Words        int
+	Sep          string
+	Capitalize   bool
+	InjectDigit  bool
+	InjectSymbol bool
+}
+
+// defaultConfig returns the tool's built-in defaults, i.e. the values used
+// when no flag, env var, or config file sets an option.
+func defaultConfig() Config {
+	return Config{
+		Length:       12,
+		SymbolSet:    symbolsSet,
+		NumPasswords: 1,
+		Format:       "plain",
+		Sep:          "-",
+	}
+}
+
+// loadConfig resolves every option down to environment variables and an
+// optional TOML config file profile; parseFlags layers CLI flags on top
+// of the result.
+func loadConfig(profileName string) (Config, error) {
+	cfg := defaultConfig()
+
+	path := configFilePath()
+	if path == "" {
+		if profileName != "" {
+			return cfg, errors.New("--profile requires $XDG_CONFIG_HOME to be set, since profiles live in $XDG_CONFIG_HOME/pwgen/config.toml")
+		}
+		if err := applyEnv(&cfg); err != nil {
+			return cfg, err
+		}
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		doc, err := parseTOMLSubset(data)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := applyTOMLValues(&cfg, doc.top); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+		if profileName != "" {
+			profile, ok := doc.profiles[profileName]
+			if !ok {
+				return cfg, fmt.Errorf("%s: unknown profile %q", path, profileName)
+			}
+			if err := applyTOMLValues(&cfg, profile); err != nil {
+				return cfg, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	case os.IsNotExist(err):
+		if profileName != "" {
+			return cfg, fmt.Errorf("--profile %q: no config file at %s", profileName, path)
+		}
+	default:
+		return cfg, err
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// configFilePath returns the path pwgen's TOML config file would live at,
+// or "" if XDG_CONFIG_HOME isn't set (the config file is entirely
+// optional).
+func configFilePath() string {
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		return ""
+	}
+	return filepath.Join(xdg, "pwgen", "config.toml")
+}
+
+// tomlDoc is the result of parsing pwgen's TOML config file: top-level
+// key/value pairs plus any [profiles.NAME] sections.
+type tomlDoc struct {
+	top      map[string]string
+	profiles map[string]map[string]string
+}
+
+// parseTOMLSubset parses the restricted subset of TOML pwgen's config
+// file needs: top-level "key = value" pairs and [profiles.NAME] section
+// headers, with string, bool, and integer values. There is deliberately
+// no support for arrays or nested tables beyond profiles.NAME; pulling in
+// a full TOML library isn't worth it for a handful of scalar settings.
+func parseTOMLSubset(data []byte) (*tomlDoc, error) {
+	doc := &tomlDoc{top: map[string]string{}, profiles: map[string]map[string]string{}}
+	current := doc.top
+
+	for i, rawLine := range strings.Split(string(data), "\n") {
+		line := strings.TrimSpace(rawLine)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			header := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+			const profilePrefix = "profiles."
+			if !strings.HasPrefix(header, profilePrefix) {
+				return nil, fmt.Errorf("line %d: unsupported section [%s]", i+1, header)
+			}
+			name := strings.TrimPrefix(header, profilePrefix)
+			profile, ok := doc.profiles[name]
+			if !ok {
+				profile = map[string]string{}
+				doc.profiles[name] = profile
+			}
+			current = profile
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key = value\"", i+1)
+		}
+		current[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return doc, nil
+}
+
+// applyTOMLValues merges values (either the config file's top-level
+// defaults or a single [profiles.NAME] section) onto cfg.
+func applyTOMLValues(cfg *Config, values map[string]string) error {
+	var err error
+	for key, value := range values {
+		switch key {
+		case "length":
+			cfg.Length, err = strconv.Atoi(value)
+		case "digits":
+			cfg.Digits, err = strconv.ParseBool(value)
+		case "symbols":
+			cfg.Symbols, err = strconv.ParseBool(value)
+		case "onlydigits":
+			cfg.OnlyDigits, err = strconv.ParseBool(value)
+		case "min-upper":
+			cfg.MinUpper, err = strconv.Atoi(value)
+		case "min-lower":
+			cfg.MinLower, err = strconv.Atoi(value)
+		case "min-digits":
+			cfg.MinDigits, err = strconv.Atoi(value)
+		case "min-symbols":
+			cfg.MinSymbols, err = strconv.Atoi(value)
+		case "exclude":
+			cfg.Exclude = value
+		case "symbol-set":
+			cfg.SymbolSet = value
+		case "n":
+			cfg.NumPasswords, err = strconv.Atoi(value)
+		case "format":
+			cfg.Format = value
+		case "words":
+			cfg.Words, err = strconv.Atoi(value)
+		case "sep":
+			cfg.Sep = value
+		case "capitalize":
+			cfg.Capitalize, err = strconv.ParseBool(value)
+		case "inject-digit":
+			cfg.InjectDigit, err = strconv.ParseBool(value)
+		case "inject-symbol":
+			cfg.InjectSymbol, err = strconv.ParseBool(value)
+		default:
+			return fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with any of the PWGEN_* environment variables
+// that are set.
+func applyEnv(cfg *Config) error {
+	envString("PWGEN_EXCLUDE", &cfg.Exclude)
+	envString("PWGEN_SYMBOL_SET", &cfg.SymbolSet)
+	envString("PWGEN_FORMAT", &cfg.Format)
+	envString("PWGEN_SEP", &cfg.Sep)
+
+	for _, e := range []struct {
+		name string
+		dst  *int
+	}{
+		{"PWGEN_LENGTH", &cfg.Length},
+		{"PWGEN_MIN_UPPER", &cfg.MinUpper},
+		{"PWGEN_MIN_LOWER", &cfg.MinLower},
+		{"PWGEN_MIN_DIGITS", &cfg.MinDigits},
+		{"PWGEN_MIN_SYMBOLS", &cfg.MinSymbols},
+		{"PWGEN_N", &cfg.NumPasswords},
+		{"PWGEN_WORDS", &cfg.Words},
+	} {
+		if err := envInt(e.name, e.dst); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range []struct {
+		name string
+		dst  *bool
+	}{
+		{"PWGEN_DIGITS", &cfg.Digits},
+		{"PWGEN_SYMBOLS", &cfg.Symbols},
+		{"PWGEN_ONLYDIGITS", &cfg.OnlyDigits},
+		{"PWGEN_CAPITALIZE", &cfg.Capitalize},
+		{"PWGEN_INJECT_DIGIT", &cfg.InjectDigit},
+		{"PWGEN_INJECT_SYMBOL", &cfg.InjectSymbol},
+	} {
+		if err := envBool(e.name, e.dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func envString(name string, dst *string) {
+	if v, ok := os.LookupEnv(name); ok {
+		*dst = v
+	}
+}
+
+func envInt(name string, dst *int) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	*dst = n
+	return nil
+}
+
+func envBool(name string, dst *bool) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	*dst = b
+	return nil
+}
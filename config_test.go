@@ -0,0 +1,197 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTOMLSubset(t *testing.T) {
+	data := []byte(`
+# a comment
+length = 16
+digits = true
+symbol-set = "!@#"
+
+[profiles.work]
+length = 24
+symbols = "true"
+sep = "_"
+
+[profiles.empty]
+`)
+
+	doc, err := parseTOMLSubset(data)
+	if err != nil {
+		t.Fatalf("parseTOMLSubset() error = %v", err)
+	}
+	wantTop := map[string]string{"length": "16", "digits": "true", "symbol-set": "!@#"}
+	for k, v := range wantTop {
+		if doc.top[k] != v {
+			t.Errorf("top[%q] = %q, want %q", k, doc.top[k], v)
+		}
+	}
+	if len(doc.top) != len(wantTop) {
+		t.Errorf("top = %v, want %v", doc.top, wantTop)
+	}
+
+	work, ok := doc.profiles["work"]
+	if !ok {
+		t.Fatal("profiles[\"work\"] missing")
+	}
+	wantWork := map[string]string{"length": "24", "symbols": "true", "sep": "_"}
+	for k, v := range wantWork {
+		if work[k] != v {
+			t.Errorf("profiles[work][%q] = %q, want %q", k, work[k], v)
+		}
+	}
+
+	if _, ok := doc.profiles["empty"]; !ok {
+		t.Error("profiles[\"empty\"] missing, want an empty profile to still be registered")
+	}
+}
+
+func TestParseTOMLSubsetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unsupported section", "[servers.alpha]\nlength = 1\n"},
+		{"missing equals", "not-a-key-value-line\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseTOMLSubset([]byte(tt.data)); err == nil {
+				t.Fatalf("parseTOMLSubset(%q) = nil error, want error", tt.data)
+			}
+		})
+	}
+}
+
+func TestApplyTOMLValues(t *testing.T) {
+	cfg := defaultConfig()
+	values := map[string]string{
+		"length":      "20",
+		"digits":      "true",
+		"min-symbols": "2",
+		"format":      "json",
+	}
+	if err := applyTOMLValues(&cfg, values); err != nil {
+		t.Fatalf("applyTOMLValues() error = %v", err)
+	}
+	if cfg.Length != 20 || !cfg.Digits || cfg.MinSymbols != 2 || cfg.Format != "json" {
+		t.Errorf("applyTOMLValues() produced %+v", cfg)
+	}
+
+	if err := applyTOMLValues(&cfg, map[string]string{"length": "not-a-number"}); err == nil {
+		t.Error("applyTOMLValues() with a non-numeric int value: got nil error, want error")
+	}
+	if err := applyTOMLValues(&cfg, map[string]string{"unknown-key": "x"}); err == nil {
+		t.Error("applyTOMLValues() with an unknown key: got nil error, want error")
+	}
+}
+
+// TestLoadConfigPrecedence checks that loadConfig layers the config file's
+// top-level defaults, a selected profile, and PWGEN_* environment variables
+// in that order, with env vars winning.
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "pwgen"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	toml := `
+length = 16
+format = "csv"
+
+[profiles.work]
+length = 24
+sep = "_"
+`
+	if err := os.WriteFile(filepath.Join(dir, "pwgen", "config.toml"), []byte(toml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("top-level defaults only", func(t *testing.T) {
+		cfg, err := loadConfig("")
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if cfg.Length != 16 || cfg.Format != "csv" {
+			t.Errorf("loadConfig(\"\") = %+v, want length=16 format=csv", cfg)
+		}
+	})
+
+	t.Run("profile overrides top-level defaults", func(t *testing.T) {
+		cfg, err := loadConfig("work")
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if cfg.Length != 24 || cfg.Sep != "_" || cfg.Format != "csv" {
+			t.Errorf("loadConfig(\"work\") = %+v, want length=24 sep=_ format=csv (inherited)", cfg)
+		}
+	})
+
+	t.Run("unknown profile errors", func(t *testing.T) {
+		if _, err := loadConfig("missing"); err == nil {
+			t.Error("loadConfig(\"missing\") = nil error, want error")
+		}
+	})
+
+	t.Run("env vars override the config file", func(t *testing.T) {
+		t.Setenv("PWGEN_LENGTH", "40")
+		cfg, err := loadConfig("work")
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if cfg.Length != 40 {
+			t.Errorf("loadConfig() Length = %d, want 40 (env override)", cfg.Length)
+		}
+		if cfg.Sep != "_" {
+			t.Errorf("loadConfig() Sep = %q, want %q (unaffected by env)", cfg.Sep, "_")
+		}
+	})
+}
+
+func TestLoadConfigNoXDGConfigHome(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("loadConfig(\"\") error = %v", err)
+	}
+	want := defaultConfig()
+	if cfg != want {
+		t.Errorf("loadConfig(\"\") = %+v, want built-in defaults %+v", cfg, want)
+	}
+
+	if _, err := loadConfig("work"); err == nil {
+		t.Error("loadConfig(\"work\") with no XDG_CONFIG_HOME: got nil error, want error")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("PWGEN_LENGTH", "32")
+	t.Setenv("PWGEN_DIGITS", "true")
+	t.Setenv("PWGEN_FORMAT", "json")
+
+	cfg := defaultConfig()
+	if err := applyEnv(&cfg); err != nil {
+		t.Fatalf("applyEnv() error = %v", err)
+	}
+	if cfg.Length != 32 {
+		t.Errorf("Length = %d, want 32", cfg.Length)
+	}
+	if !cfg.Digits {
+		t.Error("Digits = false, want true")
+	}
+	if cfg.Format != "json" {
+		t.Errorf("Format = %q, want json", cfg.Format)
+	}
+
+	t.Setenv("PWGEN_LENGTH", "not-a-number")
+	cfg = defaultConfig()
+	if err := applyEnv(&cfg); err == nil {
+		t.Error("applyEnv() with PWGEN_LENGTH=not-a-number: got nil error, want error")
+	}
+}
@@ -1,21 +1,66 @@
 package main
 
 import (
+	"bufio"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha512"
+	_ "embed"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"math/big"
+	mathbits "math/bits"
+	"os"
+	"strings"
 )
 
+//go:embed wordlist.txt
+var wordlistRaw string
+
+// wordlist is the built-in diceware word list used by --words mode.
+var wordlist = strings.Fields(wordlistRaw)
+
 var (
 	length     int
 	digits     bool
 	symbols    bool
 	onlydigits bool
+
+	minUpper   int
+	minLower   int
+	minDigits  int
+	minSymbols int
+	exclude    string
+	symbolSet  string
+
+	numPasswords int
+	format       string
+
+	site   string
+	master bool
+
+	wordsCount   int
+	sep          string
+	capitalize   bool
+	injectDigit  bool
+	injectSymbol bool
+
+	profileName string
+
+	showEntropy bool
+	minEntropy  float64
 )
 
+// validFormats are the supported values for --format.
+var validFormats = map[string]bool{"plain": true, "json": true, "csv": true}
+
 const (
 	lettersUpper = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	lettersLower = "abcdefghijklmnopqrstuvwxyz"
@@ -28,24 +73,300 @@ func init() {
 	flag.BoolVar(&digits, "d", false, "Generate passwords with digits")
 	flag.BoolVar(&symbols, "s", false, "Generate passwords with symbols")
 	flag.BoolVar(&onlydigits, "od", false, "Generate passwords with only digits")
+	flag.IntVar(&minUpper, "min-upper", 0, "Minimum number of uppercase letters (implies uppercase letters required)")
+	flag.IntVar(&minLower, "min-lower", 0, "Minimum number of lowercase letters (implies lowercase letters required)")
+	flag.IntVar(&minDigits, "min-digits", 0, "Minimum number of digits (implies -d)")
+	flag.IntVar(&minSymbols, "min-symbols", 0, "Minimum number of symbols (implies -s)")
+	flag.StringVar(&exclude, "exclude", "", "Characters to exclude from the generated password, e.g. \"Il1O0\"")
+	flag.StringVar(&symbolSet, "symbol-set", symbolsSet, "Symbol set to draw from when symbols are enabled")
+	flag.IntVar(&numPasswords, "n", 1, "Number of passwords to generate")
+	flag.StringVar(&format, "format", "plain", "Output format: plain, json, or csv")
+	flag.StringVar(&site, "site", "", "Derive a deterministic password for this site instead of generating a random one (requires --master)")
+	flag.BoolVar(&master, "master", false, "Enable deterministic site-scoped derivation (requires --site; reads the master passphrase from PWGEN_MASTER or stdin)")
+	flag.IntVar(&wordsCount, "words", 0, "Generate a diceware-style passphrase with this many words instead of a character password")
+	flag.StringVar(&sep, "sep", "-", "Separator between words in --words mode")
+	flag.BoolVar(&capitalize, "capitalize", false, "Capitalize the first letter of each word in --words mode")
+	flag.BoolVar(&injectDigit, "inject-digit", false, "Append a random digit to the passphrase in --words mode")
+	flag.BoolVar(&injectSymbol, "inject-symbol", false, "Append a random symbol to the passphrase in --words mode")
+	flag.StringVar(&profileName, "profile", "", "Named profile to load from $XDG_CONFIG_HOME/pwgen/config.toml")
+	flag.BoolVar(&showEntropy, "entropy", false, "Print the Shannon entropy and a coarse strength label alongside each password")
+	flag.Float64Var(&minEntropy, "min-entropy", 0, "Reject parameter combinations that can't reach this many bits of entropy")
 }
 
 func main() {
-	err := parseFlags()
-	if err != nil {
+	// Accept "generate" as an optional leading subcommand, equivalent to
+	// running with no subcommand at all; it exists so batch invocations
+	// read naturally (e.g. `pwgen generate -n 50 --format csv`).
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	if err := parseFlags(); err != nil {
 		log.Fatal(err)
 	}
-	password, err := generatePassword()
-	if err != nil {
-		fmt.Println(err)
+
+	if master {
+		masterPass, err := readMasterPassphrase()
+		if err != nil {
+			log.Fatal(err)
+		}
+		password, err := derivePassword(masterPass, site)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		result := passwordResult{Password: password}
+		if showEntropy {
+			charset, requiredSets, err := buildCharset()
+			if err != nil {
+				log.Fatal(err)
+			}
+			bits := passwordEntropyBits(len(charset), requiredSets, length)
+			result.EntropyBits = &bits
+			result.Strength = strengthLabel(bits)
+		}
+		if err := printPasswords([]passwordResult{result}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if wordsCount > 0 {
+		var bits float64
+		var strength string
+		if showEntropy {
+			bits = passphraseEntropyBits()
+			strength = strengthLabel(bits)
+		}
+
+		results := make([]passphraseResult, 0, numPasswords)
+		for i := 0; i < numPasswords; i++ {
+			phrase, err := generatePassphrase()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			result := passphraseResult{Password: phrase}
+			if showEntropy {
+				b := bits
+				result.EntropyBits = &b
+				result.Strength = strength
+			}
+			results = append(results, result)
+		}
+		if err := printPassphrases(results); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
-	fmt.Println("Password:", password)
+
+	// Build the charset once and reuse it across every iteration below,
+	// rather than recomputing the same exclude map and class slices on
+	// every one of -n's passwords.
+	charset, requiredSets, err := buildCharset()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var bits float64
+	var strength string
+	if showEntropy {
+		bits = passwordEntropyBits(len(charset), requiredSets, length)
+		strength = strengthLabel(bits)
+	}
+
+	results := make([]passwordResult, 0, numPasswords)
+	for i := 0; i < numPasswords; i++ {
+		password, err := buildPasswordFrom(rand.Reader, charset, requiredSets)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		result := passwordResult{Password: password}
+		if showEntropy {
+			b := bits
+			result.EntropyBits = &b
+			result.Strength = strength
+		}
+		results = append(results, result)
+	}
+
+	if err := printPasswords(results); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readMasterPassphrase returns the master passphrase for --master mode.
+// It is never accepted as a flag value so it can't leak into argv or shell
+// history; it comes from PWGEN_MASTER if set, otherwise the first line of
+// stdin.
+func readMasterPassphrase() (string, error) {
+	if v := os.Getenv("PWGEN_MASTER"); v != "" {
+		return v, nil
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", errors.New("no master passphrase: set PWGEN_MASTER or pipe it on stdin")
+	}
+	return scanner.Text(), nil
+}
+
+// passwordResult pairs a generated password with its entropy estimate,
+// when --entropy is active.
+type passwordResult struct {
+	Password    string   `json:"password"`
+	EntropyBits *float64 `json:"entropy_bits,omitempty"`
+	Strength    string   `json:"strength,omitempty"`
+}
+
+// printPasswords writes the generated passwords to stdout in the requested
+// --format. A single password under the default plain format keeps the
+// original "Password: <value>" output for backward compatibility.
+func printPasswords(results []passwordResult) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		return enc.Encode(results)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		header := []string{"password"}
+		if showEntropy {
+			header = append(header, "entropy_bits", "strength")
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, r := range results {
+			row := []string{r.Password}
+			if showEntropy {
+				row = append(row, fmt.Sprintf("%.2f", *r.EntropyBits), r.Strength)
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	default: // plain
+		if len(results) == 1 {
+			fmt.Print("Password: ", results[0].Password)
+			if showEntropy {
+				fmt.Printf(" (entropy: %.2f bits, %s)", *results[0].EntropyBits, results[0].Strength)
+			}
+			fmt.Println()
+			return nil
+		}
+		for _, r := range results {
+			fmt.Print(r.Password)
+			if showEntropy {
+				fmt.Printf(" (entropy: %.2f bits, %s)", *r.EntropyBits, r.Strength)
+			}
+			fmt.Println()
+		}
+		return nil
+	}
 }
 
-// parseFlags parses the command line flags
+// passphraseResult pairs a generated --words passphrase with its entropy
+// and strength label when --entropy is active, so callers can see the
+// strength tradeoff vs. character mode.
+type passphraseResult struct {
+	Password    string   `json:"password"`
+	EntropyBits *float64 `json:"entropy_bits,omitempty"`
+	Strength    string   `json:"strength,omitempty"`
+}
+
+// printPassphrases writes --words results to stdout in the requested
+// --format.
+func printPassphrases(results []passphraseResult) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		return enc.Encode(results)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		header := []string{"password"}
+		if showEntropy {
+			header = append(header, "entropy_bits", "strength")
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, r := range results {
+			row := []string{r.Password}
+			if showEntropy {
+				row = append(row, fmt.Sprintf("%.2f", *r.EntropyBits), r.Strength)
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	default: // plain
+		for _, r := range results {
+			fmt.Print("Password: ", r.Password)
+			if showEntropy {
+				fmt.Printf(" (entropy: %.2f bits, %s)", *r.EntropyBits, r.Strength)
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+}
+
+// parseFlags parses the command line flags, then fills in any Config-backed
+// flag not given on the command line with the layered
+// env-var/config-file/built-in value (see loadConfig), so the precedence is
+// CLI > env > config file > defaults.
 func parseFlags() error {
 	flag.Parse()
+
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	cfg, err := loadConfig(profileName)
+	if err != nil {
+		return err
+	}
+
+	applyInt := func(name string, dst *int, val int) {
+		if !explicit[name] {
+			*dst = val
+		}
+	}
+	applyBool := func(name string, dst *bool, val bool) {
+		if !explicit[name] {
+			*dst = val
+		}
+	}
+	applyString := func(name string, dst *string, val string) {
+		if !explicit[name] {
+			*dst = val
+		}
+	}
+
+	applyInt("l", &length, cfg.Length)
+	applyBool("d", &digits, cfg.Digits)
+	applyBool("s", &symbols, cfg.Symbols)
+	applyBool("od", &onlydigits, cfg.OnlyDigits)
+	applyInt("min-upper", &minUpper, cfg.MinUpper)
+	applyInt("min-lower", &minLower, cfg.MinLower)
+	applyInt("min-digits", &minDigits, cfg.MinDigits)
+	applyInt("min-symbols", &minSymbols, cfg.MinSymbols)
+	applyString("exclude", &exclude, cfg.Exclude)
+	applyString("symbol-set", &symbolSet, cfg.SymbolSet)
+	applyInt("n", &numPasswords, cfg.NumPasswords)
+	applyString("format", &format, cfg.Format)
+	applyInt("words", &wordsCount, cfg.Words)
+	applyString("sep", &sep, cfg.Sep)
+	applyBool("capitalize", &capitalize, cfg.Capitalize)
+	applyBool("inject-digit", &injectDigit, cfg.InjectDigit)
+	applyBool("inject-symbol", &injectSymbol, cfg.InjectSymbol)
+
 	if length < 1 {
 		return errors.New("length must be greater than zero")
 	}
@@ -57,79 +378,312 @@ func parseFlags() error {
 	if onlydigits && (digits || symbols) {
 		return errors.New("conflicting flags: --onlydigits cannot be combined with -d or -s")
 	}
-	return nil
-}
+	if onlydigits && (minUpper > 0 || minLower > 0 || minSymbols > 0) {
+		return errors.New("conflicting flags: --onlydigits cannot be combined with --min-upper, --min-lower, or --min-symbols")
+	}
+	if minUpper < 0 || minLower < 0 || minDigits < 0 || minSymbols < 0 {
+		return errors.New("min counts must not be negative")
+	}
+	if numPasswords < 1 {
+		return errors.New("-n must be greater than zero")
+	}
+	if !validFormats[format] {
+		return fmt.Errorf("unknown --format %q: must be plain, json, or csv", format)
+	}
+	if (site != "") != master {
+		return errors.New("--site and --master must be used together")
+	}
+	if master && numPasswords != 1 {
+		return errors.New("--master mode always derives exactly one password for the given site; remove -n")
+	}
+	if wordsCount < 0 {
+		return errors.New("--words must not be negative")
+	}
+	if wordsCount > 0 && master {
+		return errors.New("conflicting flags: --words cannot be combined with --master")
+	}
+	if minEntropy < 0 {
+		return errors.New("--min-entropy must not be negative")
+	}
+	if wordsCount > 0 {
+		if explicit["l"] {
+			return errors.New("conflicting flags: --words cannot be combined with -l")
+		}
+		if onlydigits {
+			return errors.New("conflicting flags: --words cannot be combined with --onlydigits")
+		}
+		if len(wordlist) == 0 {
+			return errors.New("--words: the built-in word list is empty")
+		}
+		if minEntropy > 0 {
+			if bits := passphraseEntropyBits(); bits < minEntropy {
+				return fmt.Errorf("--words %d yields %.2f bits of entropy, below --min-entropy %.2f", wordsCount, bits, minEntropy)
+			}
+		}
+		return nil
+	}
 
-// generatePassword generates a random password with class guarantees
-func generatePassword() (string, error) {
-	charset, requiredSets := buildCharset()
-	if len(charset) == 0 {
-		return "", errors.New("empty charset: enable at least one character class")
+	charset, requiredSets, err := buildCharset()
+	if err != nil {
+		return err
+	}
+	minTotal := 0
+	for _, spec := range requiredSets {
+		minTotal += spec.min
 	}
-	if length < len(requiredSets) {
-		return "", fmt.Errorf("length must be at least %d to include all required classes", len(requiredSets))
+	if minTotal > length {
+		return fmt.Errorf("sum of minimums (%d) exceeds length (%d)", minTotal, length)
 	}
+	if minEntropy > 0 {
+		if bits := passwordEntropyBits(len(charset), requiredSets, length); bits < minEntropy {
+			return fmt.Errorf("requested parameters yield %.2f bits of entropy, below --min-entropy %.2f", bits, minEntropy)
+		}
+	}
+	return nil
+}
 
-	// Preselect one rune from each required set to guarantee inclusion
-	passwordRunes := make([]rune, 0, length)
-	for _, set := range requiredSets {
-		r, err := randomRuneFrom(set)
+// derivePassword deterministically derives a password for (master, site,
+// length, class constraints): the same inputs always yield the same
+// password, so nothing needs to be stored. It runs the same
+// class-guarantee-then-shuffle logic as buildPasswordFrom, but draws its
+// randomness from an HKDF-SHA512 stream keyed on master with site as info
+// instead of crypto/rand.
+func derivePassword(masterPass, site string) (string, error) {
+	return buildPassword(newHKDFStream(masterPass, site))
+}
+
+// generatePassphrase emits an EFF-style diceware passphrase: wordsCount
+// words from the built-in word list joined by sep, optionally capitalized
+// and with a digit/symbol appended to satisfy sites that still require
+// mixed character classes. Word selection uses rand.Int over the word
+// list length, so there is no modulo bias.
+func generatePassphrase() (string, error) {
+	words := make([]string, wordsCount)
+	for i := range words {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(wordlist))))
 		if err != nil {
 			return "", err
 		}
-		passwordRunes = append(passwordRunes, r)
+		w := wordlist[idx.Int64()]
+		if capitalize {
+			w = strings.ToUpper(w[:1]) + w[1:]
+		}
+		words[i] = w
 	}
+	phrase := strings.Join(words, sep)
 
-	// Fill the rest from the full charset
-	max := big.NewInt(int64(len(charset)))
-	for i := len(passwordRunes); i < length; i++ {
-		idx, err := rand.Int(rand.Reader, max)
+	if injectDigit {
+		d, err := randomRuneFrom([]rune(digitsSet), rand.Reader)
 		if err != nil {
 			return "", err
 		}
-		passwordRunes = append(passwordRunes, charset[idx.Int64()])
+		phrase += string(d)
+	}
+	if injectSymbol {
+		s, err := randomRuneFrom([]rune(symbolSet), rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		phrase += string(s)
+	}
+	return phrase, nil
+}
+
+// passphraseEntropyBits returns the Shannon entropy, in bits, of a
+// passphrase made of wordsCount words drawn uniformly from wordlist.
+func passphraseEntropyBits() float64 {
+	return float64(wordsCount) * math.Log2(float64(len(wordlist)))
+}
+
+// passwordEntropyBits returns the Shannon entropy, in bits, of a password
+// built by buildPassword: each preselected character contributes
+// log2(len(its class)) rather than log2(charsetLen), since it was drawn
+// from a smaller set than the rest of the password.
+func passwordEntropyBits(charsetLen int, requiredSets []classSpec, length int) float64 {
+	bits := 0.0
+	remaining := length
+	for _, spec := range requiredSets {
+		bits += float64(spec.min) * math.Log2(float64(len(spec.runes)))
+		remaining -= spec.min
+	}
+	if remaining > 0 {
+		bits += float64(remaining) * math.Log2(float64(charsetLen))
+	}
+	return bits
+}
+
+// strengthLabel gives a coarse, zxcvbn-style label for a bit-strength estimate.
+func strengthLabel(bits float64) string {
+	switch {
+	case bits < 28:
+		return "weak"
+	case bits < 36:
+		return "fair"
+	case bits < 60:
+		return "strong"
+	default:
+		return "very strong"
 	}
+}
 
-	// Secure shuffle so required characters are not all at the beginning
-	if err := cryptoShuffle(passwordRunes); err != nil {
+// buildPassword builds the charset from the current flags and generates a
+// single password from r. Callers generating many passwords in a loop
+// (see main's batch mode) should call buildCharset once up front and use
+// buildPasswordFrom directly instead, so the same unchanging charset
+// isn't rebuilt on every iteration.
+func buildPassword(r io.Reader) (string, error) {
+	charset, requiredSets, err := buildCharset()
+	if err != nil {
+		return "", err
+	}
+	return buildPasswordFrom(r, charset, requiredSets)
+}
+
+// buildPasswordFrom runs the class-guarantee-then-shuffle selection
+// algorithm against a precomputed charset/requiredSets, drawing all
+// randomness from r.
+func buildPasswordFrom(r io.Reader, charset []rune, requiredSets []classSpec) (string, error) {
+	if len(charset) == 0 {
+		return "", errors.New("empty charset: enable at least one character class")
+	}
+	minTotal := 0
+	for _, spec := range requiredSets {
+		minTotal += spec.min
+	}
+	if length < minTotal {
+		return "", fmt.Errorf("length must be at least %d to satisfy the requested minimums", minTotal)
+	}
+
+	// Preselect the declared minimum from each required set to guarantee inclusion
+	passwordRunes := make([]rune, 0, length)
+	for _, spec := range requiredSets {
+		for i := 0; i < spec.min; i++ {
+			rn, err := randomRuneFrom(spec.runes, r)
+			if err != nil {
+				return "", err
+			}
+			passwordRunes = append(passwordRunes, rn)
+		}
+	}
+
+	// Fill the rest from the full (filtered) charset, drawing entropy in
+	// bulk rather than issuing one rand.Int call per character.
+	indices, err := randIndices(r, len(charset), length-len(passwordRunes))
+	if err != nil {
+		return "", err
+	}
+	for _, idx := range indices {
+		passwordRunes = append(passwordRunes, charset[idx])
+	}
+
+	// Shuffle so required characters are not all at the beginning
+	if err := shuffleRunes(passwordRunes, r); err != nil {
 		return "", err
 	}
 	return string(passwordRunes), nil
 }
 
-// buildCharset builds the charset to use for the password
-// and returns the list of "required" sets to guarantee inclusion.
-func buildCharset() ([]rune, [][]rune) {
+// classSpec is a character class together with the minimum number of
+// characters from it that must appear in the generated password.
+type classSpec struct {
+	runes []rune
+	min   int
+}
+
+// filterExcluded returns the runes of set that are not in excluded.
+func filterExcluded(set string, excluded map[rune]bool) []rune {
+	var out []rune
+	for _, r := range set {
+		if !excluded[r] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// buildCharset builds the charset to use for the password, applying
+// --exclude, and returns the list of required classes and their minimums.
+func buildCharset() ([]rune, []classSpec, error) {
+	excluded := make(map[rune]bool, len(exclude))
+	for _, r := range exclude {
+		excluded[r] = true
+	}
+
 	// only digits mode
 	if onlydigits {
-		return []rune(digitsSet), [][]rune{[]rune(digitsSet)}
+		d := filterExcluded(digitsSet, excluded)
+		if len(d) == 0 {
+			return nil, nil, errors.New("--exclude removes every digit, leaving no characters for --onlydigits")
+		}
+		min := minDigits
+		if min == 0 {
+			min = 1
+		}
+		return d, []classSpec{{runes: d, min: min}}, nil
 	}
 
 	var charset []rune
-	var requiredSets [][]rune
+	var requiredSets []classSpec
 
-	// Letters are always included by default
-	letters := []rune(lettersUpper + lettersLower)
-	charset = append(charset, letters...)
-	requiredSets = append(requiredSets, letters) // guarantee at least one letter
+	upper := filterExcluded(lettersUpper, excluded)
+	lower := filterExcluded(lettersLower, excluded)
+	if minUpper > 0 || minLower > 0 {
+		// Explicit per-case minimums requested: require upper and lower separately.
+		if minUpper > 0 && len(upper) == 0 {
+			return nil, nil, errors.New("--exclude removes every uppercase letter, but --min-upper requires some")
+		}
+		if minLower > 0 && len(lower) == 0 {
+			return nil, nil, errors.New("--exclude removes every lowercase letter, but --min-lower requires some")
+		}
+		charset = append(charset, upper...)
+		charset = append(charset, lower...)
+		if minUpper > 0 {
+			requiredSets = append(requiredSets, classSpec{runes: upper, min: minUpper})
+		}
+		if minLower > 0 {
+			requiredSets = append(requiredSets, classSpec{runes: lower, min: minLower})
+		}
+	} else {
+		// Default: letters are always included, at least one of either case.
+		letters := append(append([]rune{}, upper...), lower...)
+		if len(letters) == 0 {
+			return nil, nil, errors.New("--exclude removes every letter, leaving no characters for the default letter class")
+		}
+		charset = append(charset, letters...)
+		requiredSets = append(requiredSets, classSpec{runes: letters, min: 1})
+	}
 
-	if digits {
-		d := []rune(digitsSet)
+	if digits || minDigits > 0 {
+		d := filterExcluded(digitsSet, excluded)
+		if len(d) == 0 {
+			return nil, nil, errors.New("--exclude removes every digit, but digits are required")
+		}
+		min := minDigits
+		if min == 0 {
+			min = 1
+		}
 		charset = append(charset, d...)
-		requiredSets = append(requiredSets, d)
+		requiredSets = append(requiredSets, classSpec{runes: d, min: min})
 	}
-	if symbols {
-		s := []rune(symbolsSet)
+	if symbols || minSymbols > 0 {
+		s := filterExcluded(symbolSet, excluded)
+		if len(s) == 0 {
+			return nil, nil, errors.New("--exclude removes every symbol, but symbols are required")
+		}
+		min := minSymbols
+		if min == 0 {
+			min = 1
+		}
 		charset = append(charset, s...)
-		requiredSets = append(requiredSets, s)
+		requiredSets = append(requiredSets, classSpec{runes: s, min: min})
 	}
-	return charset, requiredSets
+	return charset, requiredSets, nil
 }
 
-// cryptoShuffle performs Fisher–Yates shuffle using crypto/rand
-func cryptoShuffle(rs []rune) error {
+// shuffleRunes performs a Fisher–Yates shuffle, drawing randomness from r.
+func shuffleRunes(rs []rune, r io.Reader) error {
 	for i := len(rs) - 1; i > 0; i-- {
-		jBig, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		jBig, err := rand.Int(r, big.NewInt(int64(i+1)))
 		if err != nil {
 			return err
 		}
@@ -139,14 +693,126 @@ func cryptoShuffle(rs []rune) error {
 	return nil
 }
 
-func randomRuneFrom(set []rune) (rune, error) {
+// randIndices draws count indices uniformly from [0, n) using rejection
+// sampling over masked random bytes read from r (the letterIdxBits/
+// letterIdxMask approach used by apg-go), so bulk fills need a handful of
+// reads instead of one rand.Int call per character.
+func randIndices(r io.Reader, n, count int) ([]int, error) {
+	if count == 0 {
+		return nil, nil
+	}
+	if n <= 0 {
+		return nil, errors.New("randIndices: n must be positive")
+	}
+	if n > 256 {
+		// A single masked byte can't cover [0, n); draw each index with rand.Int.
+		max := big.NewInt(int64(n))
+		indices := make([]int, count)
+		for i := range indices {
+			idx, err := rand.Int(r, max)
+			if err != nil {
+				return nil, err
+			}
+			indices[i] = int(idx.Int64())
+		}
+		return indices, nil
+	}
+
+	bitLen := mathbits.Len(uint(n - 1))
+	if bitLen == 0 {
+		bitLen = 1
+	}
+	mask := byte(1<<uint(bitLen) - 1)
+
+	indices := make([]int, 0, count)
+	buf := make([]byte, count) // optimistic; refilled on rejection
+	for len(indices) < count {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		for _, b := range buf {
+			if idx := int(b & mask); idx < n {
+				indices = append(indices, idx)
+				if len(indices) == count {
+					break
+				}
+			}
+		}
+	}
+	return indices, nil
+}
+
+// randomRuneFrom picks a uniformly random rune from set, drawing
+// randomness from r.
+func randomRuneFrom(set []rune, r io.Reader) (rune, error) {
 	n := len(set)
 	if n == 0 {
 		return 0, errors.New("empty set")
 	}
-	idx, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	idx, err := rand.Int(r, big.NewInt(int64(n)))
 	if err != nil {
 		return 0, err
 	}
 	return set[idx.Int64()], nil
 }
+
+// newHKDFStream returns a deterministic byte stream derived from
+// masterPass and site via HKDF (RFC 5869) with SHA-512, for use as the
+// entropy source behind --site/--master mode. The stream depends only on
+// master and site; changing either changes every byte of it, and changing
+// length or the enabled character classes changes how buildPasswordFrom
+// consumes it. Either way the derived password is invalidated.
+func newHKDFStream(masterPass, site string) io.Reader {
+	salt := []byte(site)
+	extract := hmac.New(sha512.New, salt)
+	extract.Write([]byte(masterPass))
+	return &hkdfExpander{prk: extract.Sum(nil), info: []byte(site)}
+}
+
+// hkdfExpander streams HKDF-Expand (RFC 5869) output one block at a time.
+// RFC 5869 limits a single expansion to 255 blocks (~16KiB of SHA-512
+// output) since its counter is one byte; that's comfortably more than a
+// single password needs, but --master's length-4096 ceiling combined with
+// shuffleRunes' per-swap rand.Int draws can still exceed it. Once a page
+// of 255 blocks is exhausted, hkdfExpander starts a new one by mixing a
+// big-endian page number into info, which is equivalent to running
+// HKDF-Expand again with a distinguishable info string per page — giving
+// an effectively unlimited stream while keeping page 0 (the common case)
+// byte-for-byte identical to plain RFC 5869 output.
+type hkdfExpander struct {
+	prk     []byte
+	info    []byte
+	page    uint32
+	counter byte
+	t       []byte
+	buf     []byte
+}
+
+func (h *hkdfExpander) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(h.buf) == 0 {
+			if h.counter == 255 {
+				h.page++
+				h.counter = 0
+				h.t = nil
+			}
+			h.counter++
+			block := hmac.New(sha512.New, h.prk)
+			block.Write(h.t)
+			block.Write(h.info)
+			if h.page > 0 {
+				var pageBytes [4]byte
+				binary.BigEndian.PutUint32(pageBytes[:], h.page)
+				block.Write(pageBytes[:])
+			}
+			block.Write([]byte{h.counter})
+			h.t = block.Sum(nil)
+			h.buf = append([]byte(nil), h.t...)
+		}
+		c := copy(p[n:], h.buf)
+		h.buf = h.buf[c:]
+		n += c
+	}
+	return n, nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sort"
+	"testing"
+)
+
+// resetFlags restores every package-level flag variable to its
+// flag.IntVar/BoolVar/StringVar default, so tests that set globals directly
+// (buildCharset, derivePassword, etc. all read them rather than taking
+// parameters) don't leak state into one another.
+func resetFlags() {
+	length = 12
+	digits = false
+	symbols = false
+	onlydigits = false
+	minUpper = 0
+	minLower = 0
+	minDigits = 0
+	minSymbols = 0
+	exclude = ""
+	symbolSet = symbolsSet
+	numPasswords = 1
+	format = "plain"
+	site = ""
+	master = false
+	wordsCount = 0
+	sep = "-"
+	capitalize = false
+	injectDigit = false
+	injectSymbol = false
+	profileName = ""
+	showEntropy = false
+	minEntropy = 0
+}
+
+// runeSet returns the sorted, deduplicated runes of rs as a string, for
+// order-independent comparisons of charset contents.
+func runeSet(rs []rune) string {
+	set := make(map[rune]bool, len(rs))
+	for _, r := range rs {
+		set[r] = true
+	}
+	out := make([]rune, 0, len(set))
+	for r := range set {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return string(out)
+}
+
+func TestBuildCharset(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func()
+		wantCharset string
+		wantMins    map[string]int // runeSet(spec.runes) -> spec.min
+		wantErr     bool
+	}{
+		{
+			name:        "default letters only",
+			setup:       func() {},
+			wantCharset: runeSet([]rune(lettersUpper + lettersLower)),
+			wantMins:    map[string]int{runeSet([]rune(lettersUpper + lettersLower)): 1},
+		},
+		{
+			name: "digits and symbols enabled",
+			setup: func() {
+				digits = true
+				symbols = true
+			},
+			wantCharset: runeSet([]rune(lettersUpper + lettersLower + digitsSet + symbolsSet)),
+			wantMins: map[string]int{
+				runeSet([]rune(lettersUpper + lettersLower)): 1,
+				runeSet([]rune(digitsSet)):                   1,
+				runeSet([]rune(symbolsSet)):                  1,
+			},
+		},
+		{
+			name: "per-class minimums split upper and lower",
+			setup: func() {
+				minUpper = 2
+				minLower = 3
+			},
+			wantCharset: runeSet([]rune(lettersUpper + lettersLower)),
+			wantMins: map[string]int{
+				runeSet([]rune(lettersUpper)): 2,
+				runeSet([]rune(lettersLower)): 3,
+			},
+		},
+		{
+			name: "min-digits implies digits without -d",
+			setup: func() {
+				minDigits = 2
+			},
+			wantCharset: runeSet([]rune(lettersUpper + lettersLower + digitsSet)),
+			wantMins: map[string]int{
+				runeSet([]rune(lettersUpper + lettersLower)): 1,
+				runeSet([]rune(digitsSet)):                   2,
+			},
+		},
+		{
+			name: "exclude removes characters from every class",
+			setup: func() {
+				digits = true
+				exclude = "0OIl"
+			},
+			wantCharset: runeSet([]rune(removeRunes(lettersUpper+lettersLower+digitsSet, "0OIl"))),
+			wantMins: map[string]int{
+				runeSet([]rune(removeRunes(lettersUpper+lettersLower, "0OIl"))): 1,
+				runeSet([]rune(removeRunes(digitsSet, "0OIl"))):                 1,
+			},
+		},
+		{
+			name: "onlydigits mode ignores letters entirely",
+			setup: func() {
+				onlydigits = true
+				minDigits = 4
+			},
+			wantCharset: runeSet([]rune(digitsSet)),
+			wantMins:    map[string]int{runeSet([]rune(digitsSet)): 4},
+		},
+		{
+			name: "exclude every digit errors when digits required",
+			setup: func() {
+				digits = true
+				exclude = digitsSet
+			},
+			wantErr: true,
+		},
+		{
+			name: "exclude every digit errors in onlydigits mode",
+			setup: func() {
+				onlydigits = true
+				exclude = digitsSet
+			},
+			wantErr: true,
+		},
+		{
+			name: "exclude every letter errors for default letter class",
+			setup: func() {
+				exclude = lettersUpper + lettersLower
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetFlags()
+			tt.setup()
+			defer resetFlags()
+
+			charset, requiredSets, err := buildCharset()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("buildCharset() = %q, nil; want error", string(charset))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildCharset() error = %v", err)
+			}
+			if got := runeSet(charset); got != tt.wantCharset {
+				t.Errorf("charset = %q, want %q", got, tt.wantCharset)
+			}
+			gotMins := make(map[string]int, len(requiredSets))
+			for _, spec := range requiredSets {
+				gotMins[runeSet(spec.runes)] = spec.min
+			}
+			if len(gotMins) != len(tt.wantMins) {
+				t.Fatalf("requiredSets = %v, want %v", gotMins, tt.wantMins)
+			}
+			for k, wantMin := range tt.wantMins {
+				if gotMins[k] != wantMin {
+					t.Errorf("requiredSets[%q] = %d, want %d", k, gotMins[k], wantMin)
+				}
+			}
+		})
+	}
+}
+
+// removeRunes returns s with every rune in cut removed; a tiny helper
+// kept local to this test so the table above reads declaratively.
+func removeRunes(s, cut string) string {
+	drop := make(map[rune]bool, len(cut))
+	for _, r := range cut {
+		drop[r] = true
+	}
+	var out []rune
+	for _, r := range s {
+		if !drop[r] {
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
+
+func TestDerivePasswordDeterministic(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	length = 20
+	digits = true
+	symbols = true
+
+	got1, err := derivePassword("hunter2", "example.com")
+	if err != nil {
+		t.Fatalf("derivePassword() error = %v", err)
+	}
+	got2, err := derivePassword("hunter2", "example.com")
+	if err != nil {
+		t.Fatalf("derivePassword() error = %v", err)
+	}
+	if got1 != got2 {
+		t.Errorf("derivePassword() not deterministic: %q != %q", got1, got2)
+	}
+	if len(got1) != length {
+		t.Errorf("len(derivePassword()) = %d, want %d", len(got1), length)
+	}
+
+	if got3, err := derivePassword("hunter2", "other.example.com"); err != nil {
+		t.Fatalf("derivePassword() error = %v", err)
+	} else if got3 == got1 {
+		t.Errorf("derivePassword() gave the same password for a different site")
+	}
+	if got4, err := derivePassword("different-master", "example.com"); err != nil {
+		t.Fatalf("derivePassword() error = %v", err)
+	} else if got4 == got1 {
+		t.Errorf("derivePassword() gave the same password for a different master")
+	}
+}
+
+// TestDerivePasswordLongLength exercises the path that used to exhaust
+// hkdfExpander's 255-block RFC 5869 budget: --master's documented maximum
+// length with the default letters-only charset, which shuffleRunes' and
+// randIndices' stream consumption used to blow through well before reaching
+// 4096 characters.
+func TestDerivePasswordLongLength(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	length = 4096
+
+	got, err := derivePassword("hunter2", "example.com")
+	if err != nil {
+		t.Fatalf("derivePassword() error = %v", err)
+	}
+	if len(got) != length {
+		t.Errorf("len(derivePassword()) = %d, want %d", len(got), length)
+	}
+
+	got2, err := derivePassword("hunter2", "example.com")
+	if err != nil {
+		t.Fatalf("derivePassword() error = %v", err)
+	}
+	if got != got2 {
+		t.Errorf("derivePassword() not deterministic at length %d", length)
+	}
+}
+
+// TestMasterModeEntropy exercises --master combined with --entropy, the
+// same combination main's `if master` branch builds a passwordResult for.
+// It used to leave EntropyBits/Strength unset even when showEntropy was
+// true, so printPasswords (plain and csv) dereferenced a nil *float64 and
+// panicked; only --format json survived, silently, via omitempty.
+func TestMasterModeEntropy(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	length = 16
+	digits = true
+	showEntropy = true
+
+	password, err := derivePassword("hunter2", "example.com")
+	if err != nil {
+		t.Fatalf("derivePassword() error = %v", err)
+	}
+
+	charset, requiredSets, err := buildCharset()
+	if err != nil {
+		t.Fatalf("buildCharset() error = %v", err)
+	}
+	bits := passwordEntropyBits(len(charset), requiredSets, length)
+	result := passwordResult{Password: password}
+	result.EntropyBits = &bits
+	result.Strength = strengthLabel(bits)
+
+	for _, f := range []string{"plain", "csv", "json"} {
+		format = f
+		t.Run(f, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("printPasswords panicked with --format %s: %v", f, r)
+				}
+			}()
+			if err := printPasswords([]passwordResult{result}); err != nil {
+				t.Fatalf("printPasswords() error = %v", err)
+			}
+		})
+	}
+}
+
+// fillNaive mirrors the original per-character rand.Int loop that
+// randIndices replaced; kept only so BenchmarkFillNaive has something to
+// compare the bulk rejection-sampling approach against.
+func fillNaive(charset []rune, n int) ([]rune, error) {
+	max := big.NewInt(int64(len(charset)))
+	out := make([]rune, 0, n)
+	for i := 0; i < n; i++ {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, charset[idx.Int64()])
+	}
+	return out, nil
+}
+
+func BenchmarkFillNaive(b *testing.B) {
+	charset := []rune(lettersUpper + lettersLower + digitsSet + symbolsSet)
+	for i := 0; i < b.N; i++ {
+		if _, err := fillNaive(charset, 32); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRandIndicesBulk(b *testing.B) {
+	charset := []rune(lettersUpper + lettersLower + digitsSet + symbolsSet)
+	for i := 0; i < b.N; i++ {
+		if _, err := randIndices(rand.Reader, len(charset), 32); err != nil {
+			b.Fatal(err)
+		}
+	}
+}